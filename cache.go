@@ -0,0 +1,32 @@
+package claude
+
+import "google.golang.org/genai"
+
+// cacheMetadataKey is the genai.Part.PartMetadata key that CachePart sets to
+// mark a part as an Anthropic prompt-cache breakpoint.
+const cacheMetadataKey = "claude:cache_control"
+
+// CachePart returns a copy of p marked as a prompt-cache breakpoint. When
+// partsToBlocks converts it, the resulting Anthropic content block is
+// stamped with cache_control: {"type": "ephemeral"}, so Claude caches
+// everything up to and including that block.
+func CachePart(p *genai.Part) *genai.Part {
+	cached := *p
+	md := make(map[string]any, len(cached.PartMetadata)+1)
+	for k, v := range cached.PartMetadata {
+		md[k] = v
+	}
+	md[cacheMetadataKey] = true
+	cached.PartMetadata = md
+	return &cached
+}
+
+// partCached reports whether p was marked as a cache breakpoint via
+// CachePart.
+func partCached(p *genai.Part) bool {
+	if p.PartMetadata == nil {
+		return false
+	}
+	marked, _ := p.PartMetadata[cacheMetadataKey].(bool)
+	return marked
+}
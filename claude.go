@@ -5,18 +5,26 @@ import (
 	"fmt"
 	"iter"
 	"log"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
 	"google.golang.org/adk/model"
-	"google.golang.org/genai"
 )
 
 type claudeModel struct {
-	name          string
-	client        anthropic.Client
-	logger        *log.Logger
-	anthropicOpts []option.RequestOption
+	name             string
+	client           anthropic.Client
+	logger           *log.Logger
+	anthropicOpts    []option.RequestOption
+	toolChoice       string
+	parallelToolUse  *bool
+	promptCache      bool
+	retryMaxAttempts int
+	retryBase        time.Duration
+	retryMax         time.Duration
+	retryClassifier  func(error) bool
+	thinkingBudget   int
 }
 
 // NewModel returns a model.LLM backed by the Anthropic Messages API.
@@ -24,8 +32,10 @@ type claudeModel struct {
 // Use AnthropicOption to pass Anthropic SDK options, and WithDebug to enable logging.
 func NewModel(modelName string, opts ...Option) model.LLM {
 	m := &claudeModel{
-		name:   modelName,
-		logger: newLogger(),
+		name:             modelName,
+		logger:           newLogger(),
+		retryMaxAttempts: 1,
+		retryClassifier:  defaultRetryClassifier,
 	}
 	for _, o := range opts {
 		o(m)
@@ -37,12 +47,22 @@ func NewModel(modelName string, opts ...Option) model.LLM {
 func (m *claudeModel) Name() string { return m.name }
 
 func (m *claudeModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
-	params := m.buildParams(req)
+	params, err := m.buildParams(req)
+	if err != nil {
+		return func(yield func(*model.LLMResponse, error) bool) {
+			yield(nil, fmt.Errorf("claude: %w", err))
+		}
+	}
 	if stream {
 		return m.generateStream(ctx, params)
 	}
 	return func(yield func(*model.LLMResponse, error) bool) {
-		msg, err := m.client.Messages.New(ctx, params)
+		var msg *anthropic.Message
+		err := m.withRetry(ctx, func() error {
+			var err error
+			msg, err = m.client.Messages.New(ctx, params)
+			return err
+		})
 		if err != nil {
 			yield(nil, fmt.Errorf("claude: %w", err))
 			return
@@ -51,51 +71,30 @@ func (m *claudeModel) GenerateContent(ctx context.Context, req *model.LLMRequest
 	}
 }
 
-func (m *claudeModel) generateStream(ctx context.Context, params anthropic.MessageNewParams) iter.Seq2[*model.LLMResponse, error] {
-	return func(yield func(*model.LLMResponse, error) bool) {
-		stream := m.client.Messages.NewStreaming(ctx, params)
-		defer stream.Close()
-
-		var msg anthropic.Message
-		for stream.Next() {
-			event := stream.Current()
-			if err := msg.Accumulate(event); err != nil {
-				yield(nil, fmt.Errorf("claude: accumulate: %w", err))
-				return
-			}
-			// Yield partial text deltas as they arrive.
-			if delta, ok := textDelta(event); ok {
-				resp := &model.LLMResponse{
-					Content: &genai.Content{
-						Role:  "model",
-						Parts: []*genai.Part{{Text: delta}},
-					},
-					Partial: true,
-				}
-				if !yield(resp, nil) {
-					return
-				}
-			}
+// withRetry calls fn, retrying retryable errors (per m.retryClassifier) up
+// to m.retryMaxAttempts total tries with backoff. It returns immediately on
+// a non-retryable error or when ctx is done.
+func (m *claudeModel) withRetry(ctx context.Context, fn func() error) error {
+	var err error
+	wait := m.retryBase
+	for attempt := 1; attempt <= m.retryMaxAttempts; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
 		}
-		if err := stream.Err(); err != nil {
-			yield(nil, fmt.Errorf("claude: stream: %w", err))
-			return
+		if attempt == m.retryMaxAttempts || !m.retryClassifier(err) {
+			return err
+		}
+		if d, ok := retryAfter(err); ok {
+			wait = d
+		} else {
+			wait = decorrelatedJitter(wait, m.retryBase, m.retryMax)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
 		}
-		// Yield the fully accumulated response.
-		resp := m.messageToLLMResponse(&msg)
-		resp.TurnComplete = true
-		yield(resp, nil)
-	}
-}
-
-// textDelta extracts text from a content_block_delta event, if present.
-func textDelta(event anthropic.MessageStreamEventUnion) (string, bool) {
-	if event.Type != "content_block_delta" {
-		return "", false
-	}
-	delta := event.AsContentBlockDelta()
-	if delta.Delta.Type == "text_delta" {
-		return delta.Delta.Text, true
 	}
-	return "", false
+	return err
 }
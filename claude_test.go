@@ -1,11 +1,16 @@
 package claude
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
 	"google.golang.org/adk/model"
 	"google.golang.org/genai"
@@ -160,6 +165,309 @@ func TestGenerateStream_text(t *testing.T) {
 	}
 }
 
+func TestGenerateStream_toolUse(t *testing.T) {
+	m := testModel(t, "testdata/stream_tool_use.sse", "text/event-stream")
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText("Show me surf spots", "user")},
+	}
+
+	var textPartials []string
+	var toolPartials []*genai.FunctionCall
+	var final *model.LLMResponse
+	for resp, err := range m.GenerateContent(t.Context(), req, true) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Partial {
+			if p := resp.Content.Parts[0]; p.Text != "" {
+				textPartials = append(textPartials, p.Text)
+			} else if p.FunctionCall != nil {
+				toolPartials = append(toolPartials, p.FunctionCall)
+			}
+			continue
+		}
+		final = resp
+	}
+
+	if len(textPartials) != 1 || textPartials[0] != "Let me check " {
+		t.Fatalf("got text partials %v, want [Let me check ]", textPartials)
+	}
+	if len(toolPartials) == 0 {
+		t.Fatal("want at least one tool_use partial")
+	}
+	last := toolPartials[len(toolPartials)-1]
+	if last.Name != "get_spots_of_interest" || last.ID != "toolu_01B" {
+		t.Errorf("got tool partial %+v, want name=get_spots_of_interest id=toolu_01B", last)
+	}
+	if last.Args["name"] != "all" {
+		t.Errorf("got tool partial args %v, want name=all", last.Args)
+	}
+
+	if final == nil {
+		t.Fatal("no final response")
+	}
+	if final.FinishReason != genai.FinishReasonStop {
+		t.Errorf("got finish reason %q, want %q", final.FinishReason, genai.FinishReasonStop)
+	}
+	fc := final.Content.Parts[len(final.Content.Parts)-1].FunctionCall
+	if fc == nil || fc.Args["name"] != "all" {
+		t.Errorf("got final function call %+v, want args name=all", fc)
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	var captured []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		captured, err = io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile("testdata/count_tokens_response.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	llm := NewModel("claude-sonnet-4-5-20250929",
+		AnthropicOption(option.WithBaseURL(ts.URL)),
+		AnthropicOption(option.WithAPIKey("test-key")),
+	)
+	m, ok := llm.(*claudeModel)
+	if !ok {
+		t.Fatal("NewModel did not return a *claudeModel")
+	}
+
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{
+			{Role: "user", Parts: []*genai.Part{{Text: "Show me surf spots"}}},
+			{Role: "model", Parts: []*genai.Part{{FunctionCall: &genai.FunctionCall{
+				ID: "toolu_01A", Name: "get_spots", Args: map[string]any{"name": "all"},
+			}}}},
+			{Role: "user", Parts: []*genai.Part{{FunctionResponse: &genai.FunctionResponse{
+				ID: "toolu_01A", Name: "get_spots", Response: map[string]any{"result": "Ocean Beach"},
+			}}}},
+		},
+		Config: &genai.GenerateContentConfig{
+			SystemInstruction: genai.NewContentFromText("You are a surf analyst.", "system"),
+			Tools: []*genai.Tool{{
+				FunctionDeclarations: []*genai.FunctionDeclaration{{
+					Name:        "get_spots",
+					Description: "Returns spots of interest.",
+				}},
+			}},
+		},
+	}
+
+	got, err := m.CountTokens(t.Context(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TotalTokens != 57 {
+		t.Errorf("got %d total tokens, want 57", got.TotalTokens)
+	}
+
+	var sent map[string]any
+	if err := json.Unmarshal(captured, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sent["max_tokens"]; ok {
+		t.Error("count_tokens request should not include max_tokens")
+	}
+	if sent["system"] == nil {
+		t.Error("want system instruction in count_tokens request")
+	}
+	if sent["tools"] == nil {
+		t.Error("want tools in count_tokens request")
+	}
+	messages := sent["messages"].([]any)
+	if len(messages) != 3 {
+		t.Errorf("got %d messages, want 3", len(messages))
+	}
+}
+
+func TestGenerate_thinking(t *testing.T) {
+	m := testModel(t, "testdata/thinking_response.json", "application/json")
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText("Find me surf spots", "user")},
+	}
+
+	var got *model.LLMResponse
+	for resp, err := range m.GenerateContent(t.Context(), req, false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = resp
+	}
+
+	if got == nil || got.Content == nil {
+		t.Fatal("no response")
+	}
+	if len(got.Content.Parts) != 3 {
+		t.Fatalf("got %d parts, want 3", len(got.Content.Parts))
+	}
+	thought := got.Content.Parts[0]
+	if !thought.Thought || thought.Text == "" {
+		t.Errorf("first part should be a thought with text, got %+v", thought)
+	}
+	if got.Content.Parts[1].Text != "Here's what I found:" {
+		t.Errorf("second part should be text, got %+v", got.Content.Parts[1])
+	}
+	fc := got.Content.Parts[2].FunctionCall
+	if fc == nil || fc.Name != "get_spots_of_interest" {
+		t.Errorf("third part should be the tool_use call, got %+v", got.Content.Parts[2])
+	}
+}
+
+func TestConvert_thinkingRoundtrip(t *testing.T) {
+	// First turn: get back a thinking+text+tool_use response, the same
+	// shape testdata/thinking_response.json encodes, with a signature on
+	// the thinking block.
+	m := testModel(t, "testdata/thinking_response.json", "application/json")
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText("Find me surf spots", "user")},
+	}
+	var turn1 *model.LLMResponse
+	for resp, err := range m.GenerateContent(t.Context(), req, false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		turn1 = resp
+	}
+
+	// Second turn: echo that response back as conversation history (plus
+	// the tool result) and inspect what gets sent over the wire.
+	var captured []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		captured, err = io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile("testdata/retry_success.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	m2 := NewModel("claude-sonnet-4-5-20250929",
+		AnthropicOption(option.WithBaseURL(ts.URL)),
+		AnthropicOption(option.WithAPIKey("test-key")),
+	)
+	req2 := &model.LLMRequest{
+		Contents: []*genai.Content{
+			genai.NewContentFromText("Find me surf spots", "user"),
+			turn1.Content,
+			{Role: "user", Parts: []*genai.Part{{FunctionResponse: &genai.FunctionResponse{
+				ID: "toolu_01C", Name: "get_spots_of_interest", Response: map[string]any{"result": "Ocean Beach"},
+			}}}},
+		},
+	}
+	for _, err := range m2.GenerateContent(t.Context(), req2, false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sent map[string]any
+	if err := json.Unmarshal(captured, &sent); err != nil {
+		t.Fatal(err)
+	}
+	messages := sent["messages"].([]any)
+	assistantMsg := messages[1].(map[string]any)
+	content := assistantMsg["content"].([]any)
+	thinkingBlock := content[0].(map[string]any)
+	if thinkingBlock["type"] != "thinking" {
+		t.Fatalf("got block type %v, want thinking", thinkingBlock["type"])
+	}
+	if thinkingBlock["signature"] != "sig123" {
+		t.Errorf("got signature %v, want sig123", thinkingBlock["signature"])
+	}
+	if thinkingBlock["thinking"] != "The user wants surf spots near Ocean Beach." {
+		t.Errorf("got thinking text %v, want original thinking text", thinkingBlock["thinking"])
+	}
+}
+
+func TestRetry_overloadedThenSuccess(t *testing.T) {
+	var attempts int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Content-Type", "application/json")
+		if attempts == 1 {
+			w.WriteHeader(529)
+			w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"overloaded"}}`))
+			return
+		}
+		data, err := os.ReadFile("testdata/retry_success.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	m := NewModel("claude-sonnet-4-5-20250929",
+		AnthropicOption(option.WithBaseURL(ts.URL)),
+		AnthropicOption(option.WithAPIKey("test-key")),
+		WithRetry(2, time.Millisecond, 10*time.Millisecond),
+	)
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText("What is the capital of France?", "user")},
+	}
+
+	var got *model.LLMResponse
+	for resp, err := range m.GenerateContent(t.Context(), req, false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = resp
+	}
+
+	if attempts != 2 {
+		t.Fatalf("got %d attempts, want 2", attempts)
+	}
+	if got == nil || got.Content == nil || len(got.Content.Parts) == 0 || got.Content.Parts[0].Text != "Paris" {
+		t.Fatalf("got %+v, want final Paris response", got)
+	}
+}
+
+func TestRetry_zeroMaxAttemptsStillCallsOnce(t *testing.T) {
+	var attempts int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type":"error","error":{"type":"invalid_request_error","message":"bad request"}}`))
+	}))
+	defer ts.Close()
+
+	m := NewModel("claude-sonnet-4-5-20250929",
+		AnthropicOption(option.WithBaseURL(ts.URL)),
+		AnthropicOption(option.WithAPIKey("test-key")),
+		WithRetry(0, time.Millisecond, 10*time.Millisecond),
+	)
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText("What is the capital of France?", "user")},
+	}
+
+	var gotErr error
+	for _, err := range m.GenerateContent(t.Context(), req, false) {
+		gotErr = err
+	}
+
+	if attempts != 1 {
+		t.Fatalf("got %d attempts, want 1", attempts)
+	}
+	if gotErr == nil {
+		t.Fatal("want error, got nil")
+	}
+}
+
 func TestConvert_systemInstruction(t *testing.T) {
 	content := &genai.Content{
 		Parts: []*genai.Part{
@@ -219,8 +527,275 @@ func TestConvert_toolResultInRequest(t *testing.T) {
 			ID: "toolu_01A", Name: "get_spots", Response: map[string]any{"result": "Ocean Beach"},
 		}}}},
 	}
-	msgs := contentsToMessages(contents, newLogger())
+	msgs, err := contentsToMessages(contents, newLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(msgs) != 3 {
 		t.Fatalf("got %d messages, want 3", len(msgs))
 	}
 }
+
+func TestResolveToolChoice(t *testing.T) {
+	tests := []struct {
+		name     string
+		toolCh   string
+		parallel *bool
+		cfg      *genai.ToolConfig
+		check    func(t *testing.T, got anthropic.ToolChoiceUnionParam)
+	}{
+		{
+			name: "default auto",
+			check: func(t *testing.T, got anthropic.ToolChoiceUnionParam) {
+				if got.OfAuto == nil {
+					t.Fatal("want OfAuto")
+				}
+			},
+		},
+		{
+			name:   "any",
+			toolCh: "any",
+			check: func(t *testing.T, got anthropic.ToolChoiceUnionParam) {
+				if got.OfAny == nil {
+					t.Fatal("want OfAny")
+				}
+			},
+		},
+		{
+			name:   "none",
+			toolCh: "none",
+			check: func(t *testing.T, got anthropic.ToolChoiceUnionParam) {
+				if got.OfNone == nil {
+					t.Fatal("want OfNone")
+				}
+			},
+		},
+		{
+			name:   "specific tool",
+			toolCh: "tool:get_spots",
+			check: func(t *testing.T, got anthropic.ToolChoiceUnionParam) {
+				if got.OfTool == nil || got.OfTool.Name != "get_spots" {
+					t.Fatalf("want OfTool named get_spots, got %+v", got.OfTool)
+				}
+			},
+		},
+		{
+			name:     "parallel tool use enabled",
+			toolCh:   "auto",
+			parallel: func() *bool { b := true; return &b }(),
+			check: func(t *testing.T, got anthropic.ToolChoiceUnionParam) {
+				if got.OfAuto == nil || got.OfAuto.DisableParallelToolUse.Value {
+					t.Fatal("want parallel tool use enabled")
+				}
+			},
+		},
+		{
+			name: "function calling config overrides option",
+			cfg: &genai.ToolConfig{
+				FunctionCallingConfig: &genai.FunctionCallingConfig{
+					Mode: genai.FunctionCallingConfigModeNone,
+				},
+			},
+			toolCh: "any",
+			check: func(t *testing.T, got anthropic.ToolChoiceUnionParam) {
+				if got.OfNone == nil {
+					t.Fatal("want OfNone")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &claudeModel{toolChoice: tt.toolCh, parallelToolUse: tt.parallel}
+			got := m.resolveToolChoice(tt.cfg)
+			tt.check(t, got)
+		})
+	}
+}
+
+func TestConvert_mediaParts(t *testing.T) {
+	png, err := os.ReadFile("testdata/tiny.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		part    *genai.Part
+		wantErr bool
+		check   func(t *testing.T, block anthropic.ContentBlockParamUnion)
+	}{
+		{
+			name: "inline image",
+			part: &genai.Part{InlineData: &genai.Blob{MIMEType: "image/png", Data: png}},
+			check: func(t *testing.T, block anthropic.ContentBlockParamUnion) {
+				if block.OfImage == nil {
+					t.Fatal("want an image block")
+				}
+				src := block.OfImage.Source.OfBase64
+				if src == nil {
+					t.Fatal("want a base64 image source")
+				}
+				if src.MediaType != "image/png" {
+					t.Errorf("got media type %q, want image/png", src.MediaType)
+				}
+				if want := base64.StdEncoding.EncodeToString(png); src.Data != want {
+					t.Errorf("got data %q, want %q", src.Data, want)
+				}
+			},
+		},
+		{
+			name: "inline pdf",
+			part: &genai.Part{InlineData: &genai.Blob{MIMEType: "application/pdf", Data: []byte("%PDF-1.4")}},
+			check: func(t *testing.T, block anthropic.ContentBlockParamUnion) {
+				if block.OfDocument == nil {
+					t.Fatal("want a document block")
+				}
+				src := block.OfDocument.Source.OfBase64
+				if src == nil {
+					t.Fatal("want a base64 document source")
+				}
+				if want := base64.StdEncoding.EncodeToString([]byte("%PDF-1.4")); src.Data != want {
+					t.Errorf("got data %q, want %q", src.Data, want)
+				}
+			},
+		},
+		{
+			name: "file uri image",
+			part: &genai.Part{FileData: &genai.FileData{MIMEType: "image/jpeg", FileURI: "https://example.com/photo.jpg"}},
+			check: func(t *testing.T, block anthropic.ContentBlockParamUnion) {
+				if block.OfImage == nil {
+					t.Fatal("want an image block")
+				}
+				src := block.OfImage.Source.OfURL
+				if src == nil {
+					t.Fatal("want a url image source")
+				}
+				if src.URL != "https://example.com/photo.jpg" {
+					t.Errorf("got url %q, want https://example.com/photo.jpg", src.URL)
+				}
+			},
+		},
+		{
+			name: "file uri pdf",
+			part: &genai.Part{FileData: &genai.FileData{MIMEType: "application/pdf", FileURI: "https://example.com/doc.pdf"}},
+			check: func(t *testing.T, block anthropic.ContentBlockParamUnion) {
+				if block.OfDocument == nil {
+					t.Fatal("want a document block")
+				}
+				src := block.OfDocument.Source.OfURL
+				if src == nil {
+					t.Fatal("want a url document source")
+				}
+				if src.URL != "https://example.com/doc.pdf" {
+					t.Errorf("got url %q, want https://example.com/doc.pdf", src.URL)
+				}
+			},
+		},
+		{
+			name:    "unsupported inline mime type",
+			part:    &genai.Part{InlineData: &genai.Blob{MIMEType: "audio/mpeg", Data: []byte("noise")}},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported file uri mime type",
+			part:    &genai.Part{FileData: &genai.FileData{MIMEType: "video/mp4", FileURI: "https://example.com/clip.mp4"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks, err := partsToBlocks([]*genai.Part{tt.part}, newLogger())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(blocks) != 1 {
+				t.Fatalf("got %d blocks, want 1", len(blocks))
+			}
+			tt.check(t, blocks[0])
+		})
+	}
+}
+
+func TestPromptCache(t *testing.T) {
+	var captured []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		captured, err = io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile("testdata/cache_response.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	m := NewModel("claude-sonnet-4-5-20250929",
+		AnthropicOption(option.WithBaseURL(ts.URL)),
+		AnthropicOption(option.WithAPIKey("test-key")),
+		WithPromptCache(),
+	)
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{
+			{Role: "user", Parts: []*genai.Part{CachePart(&genai.Part{Text: "Long context here"})}},
+		},
+		Config: &genai.GenerateContentConfig{
+			SystemInstruction: genai.NewContentFromText("You are a surf analyst.", "system"),
+			Tools: []*genai.Tool{{
+				FunctionDeclarations: []*genai.FunctionDeclaration{{
+					Name:        "get_spots",
+					Description: "Returns spots of interest.",
+				}},
+			}},
+		},
+	}
+
+	var got *model.LLMResponse
+	for resp, err := range m.GenerateContent(t.Context(), req, false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = resp
+	}
+
+	var sent map[string]any
+	if err := json.Unmarshal(captured, &sent); err != nil {
+		t.Fatal(err)
+	}
+	system := sent["system"].([]any)
+	sysBlock := system[len(system)-1].(map[string]any)
+	if _, ok := sysBlock["cache_control"]; !ok {
+		t.Error("want cache_control on system block")
+	}
+	tools := sent["tools"].([]any)
+	toolBlock := tools[len(tools)-1].(map[string]any)
+	if _, ok := toolBlock["cache_control"]; !ok {
+		t.Error("want cache_control on last tool")
+	}
+	messages := sent["messages"].([]any)
+	msg := messages[0].(map[string]any)
+	content := msg["content"].([]any)
+	part := content[0].(map[string]any)
+	if _, ok := part["cache_control"]; !ok {
+		t.Error("want cache_control on message content block")
+	}
+
+	if got.UsageMetadata == nil {
+		t.Fatal("no usage metadata")
+	}
+	if got.UsageMetadata.CachedContentTokenCount == 0 {
+		t.Error("want nonzero cached content token count")
+	}
+}
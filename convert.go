@@ -1,7 +1,9 @@
 package claude
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"log"
 	"strings"
 
@@ -12,7 +14,30 @@ import (
 
 const defaultMaxTokens int64 = 8192
 
-func (m *claudeModel) buildParams(req *model.LLMRequest) anthropic.MessageNewParams {
+// thinkingSignatureKey is the genai.Part.PartMetadata key messageToLLMResponse
+// uses to carry a thinking block's signature, so partsToBlocks can replay the
+// block unmodified on the next turn. redactedThinkingKey marks a thought part
+// that came from a redacted_thinking block rather than a thinking block, since
+// both are represented the same way in genai.Part.
+const (
+	thinkingSignatureKey = "claude:thinking_signature"
+	redactedThinkingKey  = "claude:redacted_thinking"
+)
+
+// thinkingBlock reconstructs the thinking or redacted_thinking block a
+// thought part originally came from, preserving its signature (or encrypted
+// data) verbatim. Anthropic requires thinking blocks that preceded tool use
+// to be replayed unmodified when continuing that turn; flattening them to
+// plain text blocks breaks or is rejected.
+func thinkingBlock(p *genai.Part) anthropic.ContentBlockParamUnion {
+	if redacted, _ := p.PartMetadata[redactedThinkingKey].(bool); redacted {
+		return anthropic.NewRedactedThinkingBlock(p.Text)
+	}
+	signature, _ := p.PartMetadata[thinkingSignatureKey].(string)
+	return anthropic.NewThinkingBlock(signature, p.Text)
+}
+
+func (m *claudeModel) buildParams(req *model.LLMRequest) (anthropic.MessageNewParams, error) {
 	m.logger.Printf("buildParams called with %d content(s)", len(req.Contents))
 	for i, c := range req.Contents {
 		m.logger.Printf("  content[%d] role=%s parts=%d", i, c.Role, len(c.Parts))
@@ -22,19 +47,34 @@ func (m *claudeModel) buildParams(req *model.LLMRequest) anthropic.MessageNewPar
 		}
 	}
 
+	messages, err := contentsToMessages(req.Contents, m.logger)
+	if err != nil {
+		return anthropic.MessageNewParams{}, err
+	}
 	params := anthropic.MessageNewParams{
 		Model:     anthropic.Model(m.name),
 		MaxTokens: defaultMaxTokens,
-		Messages:  contentsToMessages(req.Contents, m.logger),
+		Messages:  messages,
+	}
+	if m.thinkingBudget > 0 {
+		params.Thinking = anthropic.ThinkingConfigParamUnion{
+			OfEnabled: &anthropic.ThinkingConfigEnabledParam{
+				BudgetTokens: int64(m.thinkingBudget),
+				Type:         "enabled",
+			},
+		}
 	}
 	if req.Config == nil {
-		return params
+		return params, nil
 	}
 	if req.Config.SystemInstruction != nil {
 		if sys := systemFromContent(req.Config.SystemInstruction); len(sys) > 0 {
 			params.System = sys
 		}
 	}
+	if m.promptCache && len(params.System) > 0 {
+		params.System[len(params.System)-1].CacheControl = ephemeralCache
+	}
 	if req.Config.MaxOutputTokens > 0 {
 		params.MaxTokens = int64(req.Config.MaxOutputTokens)
 	}
@@ -49,14 +89,94 @@ func (m *claudeModel) buildParams(req *model.LLMRequest) anthropic.MessageNewPar
 	}
 	if tools := m.extractTools(req.Config.Tools); len(tools) > 0 {
 		params.Tools = tools
-		params.ToolChoice = anthropic.ToolChoiceUnionParam{
+		params.ToolChoice = m.resolveToolChoice(req.Config.ToolConfig)
+		if m.promptCache {
+			last := params.Tools[len(params.Tools)-1]
+			if last.OfTool != nil {
+				last.OfTool.CacheControl = ephemeralCache
+			}
+		}
+	}
+	return params, nil
+}
+
+// ephemeralCache is the cache_control value stamped on blocks marked for
+// Anthropic prompt caching.
+var ephemeralCache = anthropic.CacheControlEphemeralParam{Type: "ephemeral"}
+
+// setCacheControl stamps block with ephemeralCache, regardless of which
+// content block variant it holds.
+func setCacheControl(block *anthropic.ContentBlockParamUnion) {
+	switch {
+	case block.OfText != nil:
+		block.OfText.CacheControl = ephemeralCache
+	case block.OfToolUse != nil:
+		block.OfToolUse.CacheControl = ephemeralCache
+	case block.OfToolResult != nil:
+		block.OfToolResult.CacheControl = ephemeralCache
+	case block.OfImage != nil:
+		block.OfImage.CacheControl = ephemeralCache
+	case block.OfDocument != nil:
+		block.OfDocument.CacheControl = ephemeralCache
+	}
+}
+
+// resolveToolChoice determines the ToolChoiceUnionParam to send with a
+// request that declares tools. req.Config.ToolConfig, if present, takes
+// precedence over the adapter's WithToolChoice/WithParallelToolUse options.
+func (m *claudeModel) resolveToolChoice(cfg *genai.ToolConfig) anthropic.ToolChoiceUnionParam {
+	choice := m.toolChoice
+	if cfg != nil && cfg.FunctionCallingConfig != nil {
+		fc := cfg.FunctionCallingConfig
+		switch fc.Mode {
+		case genai.FunctionCallingConfigModeAny:
+			if len(fc.AllowedFunctionNames) == 1 {
+				choice = "tool:" + fc.AllowedFunctionNames[0]
+			} else {
+				choice = "any"
+			}
+		case genai.FunctionCallingConfigModeNone:
+			choice = "none"
+		case genai.FunctionCallingConfigModeAuto:
+			choice = "auto"
+		}
+	}
+
+	disableParallel := true
+	if m.parallelToolUse != nil {
+		disableParallel = !*m.parallelToolUse
+	}
+
+	if name, ok := strings.CutPrefix(choice, "tool:"); ok {
+		return anthropic.ToolChoiceUnionParam{
+			OfTool: &anthropic.ToolChoiceToolParam{
+				Type:                   "tool",
+				Name:                   name,
+				DisableParallelToolUse: anthropic.Bool(disableParallel),
+			},
+		}
+	}
+
+	switch choice {
+	case "any":
+		return anthropic.ToolChoiceUnionParam{
+			OfAny: &anthropic.ToolChoiceAnyParam{
+				Type:                   "any",
+				DisableParallelToolUse: anthropic.Bool(disableParallel),
+			},
+		}
+	case "none":
+		return anthropic.ToolChoiceUnionParam{
+			OfNone: &anthropic.ToolChoiceNoneParam{Type: "none"},
+		}
+	default:
+		return anthropic.ToolChoiceUnionParam{
 			OfAuto: &anthropic.ToolChoiceAutoParam{
 				Type:                   "auto",
-				DisableParallelToolUse: anthropic.Bool(true),
+				DisableParallelToolUse: anthropic.Bool(disableParallel),
 			},
 		}
 	}
-	return params
 }
 
 func systemFromContent(c *genai.Content) []anthropic.TextBlockParam {
@@ -72,13 +192,16 @@ func systemFromContent(c *genai.Content) []anthropic.TextBlockParam {
 	return []anthropic.TextBlockParam{{Type: "text", Text: strings.Join(parts, "\n")}}
 }
 
-func contentsToMessages(contents []*genai.Content, logger *log.Logger) []anthropic.MessageParam {
+func contentsToMessages(contents []*genai.Content, logger *log.Logger) ([]anthropic.MessageParam, error) {
 	var msgs []anthropic.MessageParam
 	for _, c := range contents {
 		if c == nil {
 			continue
 		}
-		blocks := partsToBlocks(c.Parts, logger)
+		blocks, err := partsToBlocks(c.Parts, logger)
+		if err != nil {
+			return nil, err
+		}
 		if len(blocks) == 0 {
 			continue
 		}
@@ -88,13 +211,15 @@ func contentsToMessages(contents []*genai.Content, logger *log.Logger) []anthrop
 			msgs = append(msgs, anthropic.NewUserMessage(blocks...))
 		}
 	}
-	return msgs
+	return msgs, nil
 }
 
-func partsToBlocks(parts []*genai.Part, logger *log.Logger) []anthropic.ContentBlockParamUnion {
+func partsToBlocks(parts []*genai.Part, logger *log.Logger) ([]anthropic.ContentBlockParamUnion, error) {
 	var blocks []anthropic.ContentBlockParamUnion
 	for _, p := range parts {
 		switch {
+		case p.Thought:
+			blocks = append(blocks, thinkingBlock(p))
 		case p.Text != "":
 			blocks = append(blocks, anthropic.NewTextBlock(p.Text))
 		case p.FunctionCall != nil:
@@ -112,9 +237,59 @@ func partsToBlocks(parts []*genai.Part, logger *log.Logger) []anthropic.ContentB
 				string(content),
 				false,
 			))
+		case p.InlineData != nil:
+			block, err := inlineDataBlock(p.InlineData)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, block)
+		case p.FileData != nil:
+			block, err := fileDataBlock(p.FileData)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, block)
+		default:
+			continue
 		}
+		if partCached(p) {
+			setCacheControl(&blocks[len(blocks)-1])
+		}
+	}
+	return blocks, nil
+}
+
+// inlineDataBlock converts a base64-inline genai.Blob into an Anthropic
+// image or document content block based on its MIME type.
+func inlineDataBlock(blob *genai.Blob) (anthropic.ContentBlockParamUnion, error) {
+	data := base64.StdEncoding.EncodeToString(blob.Data)
+	switch {
+	case strings.HasPrefix(blob.MIMEType, "image/"):
+		return anthropic.NewImageBlockBase64(blob.MIMEType, data), nil
+	case blob.MIMEType == "application/pdf":
+		return anthropic.NewDocumentBlock(anthropic.Base64PDFSourceParam{Data: data}), nil
+	default:
+		return anthropic.ContentBlockParamUnion{}, fmt.Errorf("claude: unsupported inline data MIME type %q", blob.MIMEType)
+	}
+}
+
+// fileDataBlock converts a URL-referenced genai.FileData into an Anthropic
+// image or document content block based on its MIME type.
+func fileDataBlock(fd *genai.FileData) (anthropic.ContentBlockParamUnion, error) {
+	switch {
+	case strings.HasPrefix(fd.MIMEType, "image/"):
+		return anthropic.ContentBlockParamUnion{
+			OfImage: &anthropic.ImageBlockParam{
+				Source: anthropic.ImageBlockParamSourceUnion{
+					OfURL: &anthropic.URLImageSourceParam{URL: fd.FileURI},
+				},
+			},
+		}, nil
+	case fd.MIMEType == "application/pdf":
+		return anthropic.NewDocumentBlock(anthropic.URLPDFSourceParam{URL: fd.FileURI}), nil
+	default:
+		return anthropic.ContentBlockParamUnion{}, fmt.Errorf("claude: unsupported file data MIME type %q", fd.MIMEType)
 	}
-	return blocks
 }
 
 func (m *claudeModel) extractTools(tools []*genai.Tool) []anthropic.ToolUnionParam {
@@ -213,12 +388,24 @@ func (m *claudeModel) messageToLLMResponse(msg *anthropic.Message) *model.LLMRes
 					Args: args,
 				},
 			})
+		case "thinking":
+			parts = append(parts, &genai.Part{
+				Text:         block.Thinking,
+				Thought:      true,
+				PartMetadata: map[string]any{thinkingSignatureKey: block.Signature},
+			})
+		case "redacted_thinking":
+			parts = append(parts, &genai.Part{
+				Text:         block.Data,
+				Thought:      true,
+				PartMetadata: map[string]any{redactedThinkingKey: true},
+			})
 		}
 	}
 	return &model.LLMResponse{
 		Content:       &genai.Content{Role: "model", Parts: parts},
 		FinishReason:  stopToFinish(msg.StopReason),
-		UsageMetadata: usageToMetadata(msg.Usage),
+		UsageMetadata: m.usageToMetadata(msg.Usage),
 	}
 }
 
@@ -235,10 +422,14 @@ func stopToFinish(reason anthropic.StopReason) genai.FinishReason {
 	}
 }
 
-func usageToMetadata(u anthropic.Usage) *genai.GenerateContentResponseUsageMetadata {
+func (m *claudeModel) usageToMetadata(u anthropic.Usage) *genai.GenerateContentResponseUsageMetadata {
+	if u.CacheCreationInputTokens > 0 {
+		m.logger.Printf("usage: cache_creation_input_tokens=%d", u.CacheCreationInputTokens)
+	}
 	return &genai.GenerateContentResponseUsageMetadata{
-		PromptTokenCount:     int32(u.InputTokens),
-		CandidatesTokenCount: int32(u.OutputTokens),
-		TotalTokenCount:      int32(u.InputTokens + u.OutputTokens),
+		PromptTokenCount:        int32(u.InputTokens),
+		CandidatesTokenCount:    int32(u.OutputTokens),
+		TotalTokenCount:         int32(u.InputTokens + u.OutputTokens),
+		CachedContentTokenCount: int32(u.CacheReadInputTokens),
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go/option"
 )
@@ -26,6 +27,71 @@ func AnthropicOption(opt option.RequestOption) Option {
 	}
 }
 
+// WithToolChoice sets the default tool_choice mode the adapter uses whenever
+// tools are declared on a request. mode must be one of "auto", "any", "none",
+// or "tool:<name>" to force a specific tool. It is overridden on a
+// per-request basis by req.Config.ToolConfig.FunctionCallingConfig, if set.
+// The default is "auto".
+func WithToolChoice(mode string) Option {
+	return func(m *claudeModel) {
+		m.toolChoice = mode
+	}
+}
+
+// WithParallelToolUse controls whether Claude may call multiple tools in a
+// single turn. It defaults to disabled, matching the adapter's historical
+// behavior.
+func WithParallelToolUse(enabled bool) Option {
+	return func(m *claudeModel) {
+		m.parallelToolUse = &enabled
+	}
+}
+
+// WithPromptCache enables Anthropic prompt caching for the adapter. It
+// stamps cache_control: {"type": "ephemeral"} on the system block and on the
+// last declared tool, so both are reused from cache on subsequent requests.
+// Use CachePart to mark additional message content as cache breakpoints.
+func WithPromptCache() Option {
+	return func(m *claudeModel) {
+		m.promptCache = true
+	}
+}
+
+// WithRetry enables retrying retryable Anthropic API errors (HTTP 429, HTTP
+// 529, and overloaded_error responses) for up to maxAttempts total tries.
+// Backoff honors the server's Retry-After header when present, otherwise it
+// uses decorrelated jitter bounded by [base, max]. Streaming requests are
+// only retried if no response has been yielded to the caller yet.
+func WithRetry(maxAttempts int, base, max time.Duration) Option {
+	return func(m *claudeModel) {
+		if maxAttempts < 1 {
+			maxAttempts = 1
+		}
+		m.retryMaxAttempts = maxAttempts
+		m.retryBase = base
+		m.retryMax = max
+	}
+}
+
+// WithRetryClassifier overrides the function used to decide whether an
+// error returned by the Anthropic API is retryable. It defaults to
+// retrying HTTP 429/529 and overloaded_error responses.
+func WithRetryClassifier(classify func(error) bool) Option {
+	return func(m *claudeModel) {
+		m.retryClassifier = classify
+	}
+}
+
+// WithThinking enables Claude's extended thinking mode with the given token
+// budget. Resulting thinking and redacted_thinking blocks are translated
+// into genai.Parts with Thought set to true, interleaved in place with the
+// rest of the response's text and tool_use parts.
+func WithThinking(budgetTokens int) Option {
+	return func(m *claudeModel) {
+		m.thinkingBudget = budgetTokens
+	}
+}
+
 func newLogger() *log.Logger {
 	return log.New(io.Discard, "", 0)
 }
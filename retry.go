@@ -0,0 +1,57 @@
+package claude
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// defaultRetryClassifier reports whether err is a retryable Anthropic API
+// error: HTTP 429 (rate limited), HTTP 529, or the overloaded_error API
+// error type.
+func defaultRetryClassifier(err error) bool {
+	var apiErr *anthropic.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode == 529 {
+		return true
+	}
+	return apiErr.Type() == "overloaded_error"
+}
+
+// retryAfter extracts the Retry-After header from err's HTTP response, if
+// present.
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *anthropic.Error
+	if !errors.As(err, &apiErr) || apiErr.Response == nil {
+		return 0, false
+	}
+	v := apiErr.Response.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	secs, parseErr := strconv.Atoi(v)
+	if parseErr != nil {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+// decorrelatedJitter returns the next backoff duration using decorrelated
+// jitter: a random value in [base, prev*3], capped at max.
+func decorrelatedJitter(prev, base, max time.Duration) time.Duration {
+	upper := prev * 3
+	if upper < base {
+		upper = base
+	}
+	d := base + time.Duration(rand.Int63n(int64(upper-base+1)))
+	if d > max {
+		d = max
+	}
+	return d
+}
@@ -0,0 +1,188 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// streamToolCall accumulates the input_json_delta fragments Anthropic sends
+// for a single in-progress tool_use content block.
+type streamToolCall struct {
+	id, name string
+	args     strings.Builder
+}
+
+// tryParse attempts to parse the JSON accumulated so far. It returns false
+// while the buffered fragments don't yet form a complete JSON object, which
+// is expected for most deltas.
+func (t *streamToolCall) tryParse() (map[string]any, bool) {
+	var args map[string]any
+	if err := json.Unmarshal([]byte(t.args.String()), &args); err != nil {
+		return nil, false
+	}
+	return args, true
+}
+
+func (m *claudeModel) generateStream(ctx context.Context, params anthropic.MessageNewParams) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		var yielded bool
+		wait := m.retryBase
+		for attempt := 1; ; attempt++ {
+			stop, err := m.streamOnce(ctx, params, yield, &yielded)
+			if stop || err == nil {
+				return
+			}
+			// Only retry a stream that hasn't delivered anything to the
+			// caller yet; replaying would duplicate already-seen output.
+			if yielded || attempt >= m.retryMaxAttempts || !m.retryClassifier(err) {
+				yield(nil, err)
+				return
+			}
+			if d, ok := retryAfter(err); ok {
+				wait = d
+			} else {
+				wait = decorrelatedJitter(wait, m.retryBase, m.retryMax)
+			}
+			select {
+			case <-ctx.Done():
+				yield(nil, err)
+				return
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+// streamOnce runs a single streaming attempt over the Anthropic SSE stream.
+// It reports whether the caller asked to stop early (by returning false
+// from yield) and, on failure, the terminal error without yielding it — the
+// caller decides whether to retry or surface it. *yielded is set once any
+// response has been delivered to the caller, across attempts.
+func (m *claudeModel) streamOnce(ctx context.Context, params anthropic.MessageNewParams, yield func(*model.LLMResponse, error) bool, yielded *bool) (stop bool, retErr error) {
+	stream := m.client.Messages.NewStreaming(ctx, params)
+	defer stream.Close()
+
+	emit := func(resp *model.LLMResponse) bool {
+		*yielded = true
+		return yield(resp, nil)
+	}
+
+	toolCalls := map[int64]*streamToolCall{}
+	var msg anthropic.Message
+	for stream.Next() {
+		event := stream.Current()
+		if err := msg.Accumulate(event); err != nil {
+			return false, fmt.Errorf("claude: accumulate: %w", err)
+		}
+
+		switch event.Type {
+		case "content_block_start":
+			start := event.AsContentBlockStart()
+			switch start.ContentBlock.Type {
+			case "tool_use":
+				tu := start.ContentBlock.AsToolUse()
+				toolCalls[event.Index] = &streamToolCall{id: tu.ID, name: tu.Name}
+			case "redacted_thinking":
+				rt := start.ContentBlock.AsRedactedThinking()
+				resp := &model.LLMResponse{
+					Content: &genai.Content{
+						Role:  "model",
+						Parts: []*genai.Part{{Text: rt.Data, Thought: true}},
+					},
+					Partial: true,
+				}
+				if !emit(resp) {
+					return true, nil
+				}
+			}
+		case "content_block_delta":
+			delta := event.AsContentBlockDelta()
+			switch delta.Delta.Type {
+			case "text_delta":
+				resp := &model.LLMResponse{
+					Content: &genai.Content{
+						Role:  "model",
+						Parts: []*genai.Part{{Text: delta.Delta.Text}},
+					},
+					Partial: true,
+				}
+				if !emit(resp) {
+					return true, nil
+				}
+			case "thinking_delta":
+				resp := &model.LLMResponse{
+					Content: &genai.Content{
+						Role:  "model",
+						Parts: []*genai.Part{{Text: delta.Delta.Thinking, Thought: true}},
+					},
+					Partial: true,
+				}
+				if !emit(resp) {
+					return true, nil
+				}
+			case "input_json_delta":
+				tc, ok := toolCalls[event.Index]
+				if !ok {
+					continue
+				}
+				tc.args.WriteString(delta.Delta.PartialJSON)
+				args, ok := tc.tryParse()
+				if !ok {
+					continue
+				}
+				resp := &model.LLMResponse{
+					Content: &genai.Content{
+						Role: "model",
+						Parts: []*genai.Part{{
+							FunctionCall: &genai.FunctionCall{ID: tc.id, Name: tc.name, Args: args},
+						}},
+					},
+					Partial: true,
+				}
+				if !emit(resp) {
+					return true, nil
+				}
+			}
+		case "content_block_stop":
+			tc, ok := toolCalls[event.Index]
+			if !ok {
+				continue
+			}
+			delete(toolCalls, event.Index)
+			args, _ := tc.tryParse()
+			resp := &model.LLMResponse{
+				Content: &genai.Content{
+					Role: "model",
+					Parts: []*genai.Part{{
+						FunctionCall: &genai.FunctionCall{ID: tc.id, Name: tc.name, Args: args},
+					}},
+				},
+				Partial: true,
+			}
+			if !emit(resp) {
+				return true, nil
+			}
+		case "message_delta":
+			md := event.AsMessageDelta()
+			if md.Delta.StopReason != "" {
+				m.logger.Printf("generateStream: message_delta stop_reason=%s", md.Delta.StopReason)
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return false, fmt.Errorf("claude: stream: %w", err)
+	}
+	// Emit the fully accumulated response.
+	resp := m.messageToLLMResponse(&msg)
+	resp.TurnComplete = true
+	emit(resp)
+	return false, nil
+}
@@ -0,0 +1,46 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"google.golang.org/adk/model"
+)
+
+// CountTokensResponse is the result of sizing a request against Anthropic's
+// /v1/messages/count_tokens endpoint. There is no corresponding type in
+// google.golang.org/adk/model, so the adapter defines its own.
+type CountTokensResponse struct {
+	TotalTokens int64
+}
+
+// CountTokens sizes req against Anthropic's /v1/messages/count_tokens
+// endpoint, using the same conversion as buildParams minus generation-only
+// fields (max tokens, temperature, top_p, stop sequences), letting callers
+// check a prompt against context limits before dispatching a full
+// generation.
+func (m *claudeModel) CountTokens(ctx context.Context, req *model.LLMRequest) (*CountTokensResponse, error) {
+	params, err := m.buildParams(req)
+	if err != nil {
+		return nil, err
+	}
+	tools := make([]anthropic.MessageCountTokensToolUnionParam, len(params.Tools))
+	for i, t := range params.Tools {
+		tools[i] = anthropic.MessageCountTokensToolUnionParam(t)
+	}
+	countParams := anthropic.MessageCountTokensParams{
+		Model:      params.Model,
+		Messages:   params.Messages,
+		System:     anthropic.MessageCountTokensParamsSystemUnion{OfTextBlockArray: params.System},
+		Tools:      tools,
+		ToolChoice: params.ToolChoice,
+		Thinking:   params.Thinking,
+	}
+
+	resp, err := m.client.Messages.CountTokens(ctx, countParams)
+	if err != nil {
+		return nil, fmt.Errorf("claude: count tokens: %w", err)
+	}
+	return &CountTokensResponse{TotalTokens: resp.InputTokens}, nil
+}